@@ -0,0 +1,83 @@
+package filecache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+)
+
+// Codec marshals and unmarshals the values `GetDecoded`/`SetEncoded` and
+// `DecodeFrom`/`EncodeTo` store in a FileCache. Set one with `WithCodec`;
+// defaults to `GobCodec`.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	b := new(bytes.Buffer)
+
+	if err := gob.NewEncoder(b).Encode(v); err != nil {
+		return nil, fmt.Errorf("failed to encode value as gob: %w", err)
+	}
+
+	return b.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode gob: %w", err)
+	}
+
+	return nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("filecache: raw codec can't marshal %T, want []byte", v)
+	}
+
+	return b, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	p, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("filecache: raw codec can't unmarshal into %T, want *[]byte", v)
+	}
+
+	*p = append((*p)[:0], data...)
+
+	return nil
+}
+
+// Built-in codecs. GobCodec is the default.
+var (
+	// GobCodec encodes with encoding/gob. Go-only and not stable across type
+	// renames, but needs no schema.
+	GobCodec Codec = gobCodec{}
+
+	// JSONCodec encodes with encoding/json, for interoperating with
+	// non-Go readers or keeping cached values human-readable.
+	JSONCodec Codec = jsonCodec{}
+
+	// RawCodec passes `[]byte` values through unchanged. Marshal requires a
+	// `[]byte` and Unmarshal requires a `*[]byte`.
+	RawCodec Codec = rawCodec{}
+)
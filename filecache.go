@@ -1,31 +1,24 @@
 package filecache
 
 import (
-	"bytes"
-	"encoding/gob"
 	"fmt"
+	"io"
 	"os"
-	"path/filepath"
 	"sync"
 	"time"
 )
 
 // A cache that uses the temporary directory to cache data.
+//
+// FileCache is a handle onto state shared by every FileCache constructed
+// with the same namespace (see `New`): they all see the same entries, share
+// a single vacuum goroutine, and don't race each other on disk.
 type FileCache struct {
 	namespace string
+	state     *sharedState
 
-	mu       sync.Mutex
-	wg       sync.WaitGroup
-	keyItem  map[string]*item
-	pipe     chan string
-	shutdown chan struct{}
-	closed   bool
-
-	pipeSize      uint
-	maxItems      uint
-	maxSize       int64
-	ttl           time.Duration
-	checkInterval time.Duration
+	mu     sync.Mutex
+	closed bool
 }
 
 // Some useful size constants.
@@ -54,7 +47,7 @@ var (
 
 // Returns the decoded value of the item with the given key. Is supposed
 // that the value associated with the given key was properly encoded with
-// encoding/gob using `SetEncoded`.
+// fc's configured Codec (see `WithCodec`) using `SetEncoded`.
 //
 // It will return `ErrNotFound` if the item is not found.
 func GetDecoded[T any](fc *FileCache, key string) (T, error) {
@@ -65,26 +58,71 @@ func GetDecoded[T any](fc *FileCache, key string) (T, error) {
 		return t, err
 	}
 
-	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&t)
-	if err != nil {
-		return t, fmt.Errorf("failed to decode gob: %w", err)
+	if err := fc.state.codec.Unmarshal(data, &t); err != nil {
+		return t, err
 	}
 
 	return t, nil
 }
 
-// Wrapper for `(*FileCache).Set` that first encodes the value with encoding/gob.
+// Wrapper for `(*FileCache).Set` that first encodes the value with fc's
+// configured Codec (see `WithCodec`).
 //
 // It could return `ErrTooLarge` and `ErrNotFound`.
 func SetEncoded[T any](fc *FileCache, key string, v T) error {
-	b := new(bytes.Buffer)
+	data, err := fc.state.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return fc.Set(key, data)
+}
+
+// EncodeTo encodes v with fc's configured Codec and streams it to disk via
+// `SetWriter`, so large encoded values go straight to the underlying file
+// instead of being written through `Set`.
+func EncodeTo[T any](fc *FileCache, key string, v T) error {
+	data, err := fc.state.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	w, err := fc.SetWriter(key)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+// DecodeFrom streams the content at key from disk via `GetReader` and
+// decodes it with fc's configured Codec.
+//
+// It will return `ErrNotFound` if the item is not found.
+func DecodeFrom[T any](fc *FileCache, key string) (T, error) {
+	var t T
 
-	err := gob.NewEncoder(b).Encode(v)
+	r, err := fc.GetReader(key)
 	if err != nil {
-		return fmt.Errorf("failed to encode value as gob: %w", err)
+		return t, err
 	}
+	defer r.Close()
 
-	return fc.Set(key, b.Bytes())
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return t, err
+	}
+
+	if err := fc.state.codec.Unmarshal(data, &t); err != nil {
+		return t, err
+	}
+
+	return t, nil
 }
 
 // Creates a new file-based cache with the given namespace.
@@ -93,80 +131,58 @@ func SetEncoded[T any](fc *FileCache, key string, v T) error {
 // the cache files.
 //
 // The options are optional and can be used to customize the cache behavior.
-// See the `With*` functions for more information.
+// See the `With*` functions for more information. If another FileCache for
+// the same namespace already exists in this process, the two share the same
+// underlying state and the options passed here are ignored.
 func New(namespace string, options ...fileCacheOptFn) *FileCache {
-	fc := FileCache{
-		checkInterval: defaultCheckInterval,
-		pipeSize:      defaultPipeSize,
-		maxItems:      defaultMaxSize,
-		maxSize:       defaultMaxSize,
-		ttl:           defaultTTL,
-	}
-
-	for _, opt := range options {
-		opt(&fc)
+	return &FileCache{
+		namespace: namespace,
+		state:     acquireSharedState(namespace, options),
 	}
-
-	fc.pipe = make(chan string, fc.pipeSize)
-	fc.keyItem = make(map[string]*item, 0)
-	fc.shutdown = make(chan struct{}, 1)
-	fc.namespace = namespace
-
-	go fc.vacuum()
-
-	return &fc
 }
 
 // Retrieves the content from the memory or file-system with the given key.
 func (fc *FileCache) Get(key string) ([]byte, error) {
-	item, err := fc.getItem(key)
-	if err != nil {
-		return nil, err
-	}
-
-	return item.Access(), nil
+	return fc.state.get(key)
 }
 
 // Checks if the item with the given key exists in the memory or file-system.
 func (fc *FileCache) Exists(key string) bool {
-	_, err := fc.getItem(key)
-
-	return err == nil
+	return fc.state.exists(key)
 }
 
 // Sets the content with the given key in the memory and file-system.
+//
+// If the write fails because the disk is full, the cache synchronously
+// evicts its least-recently-used entries and retries once before
+// surfacing the error.
 func (fc *FileCache) Set(key string, content []byte) error {
-	path := fc.keyToPath(key)
-
-	item, err := setCacheItem(path, content, fc.maxSize)
-	if err != nil {
-		return err
-	}
-
-	fc.mu.Lock()
-	fc.keyItem[key] = item
-	fc.mu.Unlock()
-
-	return nil
+	return fc.state.set(key, content)
 }
 
 // Deletes the content with the given key from the memory and file-system.
 func (fc *FileCache) Delete(key string) error {
-	path := fc.keyToPath(key)
-
-	fc.mu.Lock()
-	delete(fc.keyItem, key)
-	fc.mu.Unlock()
-
-	return deleteCacheItem(path)
+	return fc.state.delete(key)
 }
 
-// The total number of items stored in memory.
+// The total number of items currently held in memory. Items tracked only by
+// path, size and mtime on disk (see `WithMemoryLimit`) don't count.
 func (fc *FileCache) SizeInMemory() int {
-	fc.mu.Lock()
-	defer fc.mu.Unlock()
+	return fc.state.sizeInMemory()
+}
+
+// Stats describes the cache's current footprint and how much eviction it's
+// done to stay within its `maxItems`/`maxSize` quotas.
+type Stats struct {
+	Items     int
+	Bytes     int64
+	Evictions uint64
+}
 
-	return len(fc.keyItem)
+// Stats reports the number of items and total bytes currently tracked by
+// the cache, and how many entries have been evicted so far.
+func (fc *FileCache) Stats() Stats {
+	return fc.state.stats()
 }
 
 // Alias for `(*FileSystem).Shutdown`. Created to implement the io.Closer interface.
@@ -176,34 +192,29 @@ func (fc *FileCache) Close() error {
 	return nil
 }
 
-// Removes the in-memory cache. The filesystem cache is not changed because
-// maybe the program will initialize a new cache with the same namespace in
-// a near future.
+// Removes this handle's reference to the in-memory cache. Once every
+// FileCache sharing this namespace has called Shutdown, the vacuum
+// goroutine stops and the in-memory index is dropped. The filesystem cache
+// is not changed because maybe the program will initialize a new cache with
+// the same namespace in a near future.
 //
-// Unnecessary calls if `(*FileSystem).Destroy` was already called.
+// Unnecessary calls if `(*FileCache).Destroy` was already called.
 func (fc *FileCache) Shutdown() {
-	close(fc.pipe)
-	close(fc.shutdown)
-	<-time.After(time.Microsecond)
-
 	fc.mu.Lock()
-
-	for key := range fc.keyItem {
-		delete(fc.keyItem, key)
+	if fc.closed {
+		fc.mu.Unlock()
+		return
 	}
 
-	fc.keyItem = nil
-
+	fc.closed = true
 	fc.mu.Unlock()
 
-	fc.wg.Wait()
+	releaseSharedState(fc.state)
 }
 
 // Destroys the in-memory cache and the filesystem cache.
 func (fc *FileCache) Destroy() error {
-	if !fc.closed {
-		fc.Shutdown()
-	}
+	fc.Shutdown()
 
 	dir := fc.getNamespaceDir()
 
@@ -216,87 +227,9 @@ func (fc *FileCache) Destroy() error {
 }
 
 func (fc *FileCache) keyToPath(key string) string {
-	return filepath.Join(fc.getNamespaceDir(), key)
+	return fc.state.keyToPath(key)
 }
 
 func (fc *FileCache) getNamespaceDir() string {
-	return filepath.Join(os.TempDir(), "fc-namespaces", fc.namespace)
-}
-
-func (fc *FileCache) getItem(key string) (*item, error) {
-	fc.mu.Lock()
-	defer fc.mu.Unlock()
-
-	if item, ok := fc.keyItem[key]; ok {
-		return item, nil
-	}
-
-	path := fc.keyToPath(key)
-
-	item, err := getCacheItem(path, fc.maxSize)
-	if err != nil {
-		return nil, err
-	}
-
-	fc.keyItem[key] = item
-
-	return item, nil
-}
-
-func (fc *FileCache) removeItem(key string, onlyMemory bool) {
-	_, err := fc.getItem(key)
-	if err == nil {
-		fc.mu.Lock()
-		delete(fc.keyItem, key)
-		fc.mu.Unlock()
-
-		if !onlyMemory {
-			path := fc.keyToPath(key)
-			deleteCacheItem(path)
-		}
-	}
-}
-
-func (fc *FileCache) removeOldest(force bool) error {
-	var lastAccessedAt time.Time
-
-	oldestKey := ""
-
-	for key, item := range fc.keyItem {
-		if force && oldestKey != "" {
-			lastAccessedAt = item.AccesedAt
-			oldestKey = key
-		} else if item.AccesedAt.Before(lastAccessedAt) {
-			lastAccessedAt = item.AccesedAt
-			oldestKey = key
-		}
-	}
-
-	if oldestKey != "" {
-		fc.removeItem(oldestKey, true)
-	}
-
-	return nil
-}
-
-func (fc *FileCache) vacuum() {
-	if fc.checkInterval < 1 {
-		return
-	}
-
-	fc.wg.Add(1)
-
-	for {
-		select {
-		case _ = <-fc.shutdown:
-			fc.wg.Done()
-			return
-		case <-time.After(fc.checkInterval):
-			for key, item := range fc.keyItem {
-				if item.Duration() > fc.ttl {
-					fc.removeItem(key, false)
-				}
-			}
-		}
-	}
+	return fc.state.getNamespaceDir()
 }
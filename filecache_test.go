@@ -1,7 +1,10 @@
 package filecache_test
 
 import (
+	"bytes"
 	"errors"
+	"io"
+	"os"
 	"testing"
 	"time"
 
@@ -123,3 +126,262 @@ func TestShutdown(t *testing.T) {
 	t.Log("as expected, the value was removed from the cache")
 	t.Logf("extraordinary: %s", data)
 }
+
+func TestStreamingPartialWrite(t *testing.T) {
+	t.Parallel()
+
+	cache := filecache.New("streaming-test")
+	defer cache.Destroy()
+
+	w, err := cache.SetWriter("large-item")
+	if err != nil {
+		t.Fatalf("failed to open writer: %v", err)
+	}
+
+	if _, err := w.Write([]byte("first chunk,")); err != nil {
+		t.Fatalf("failed to write the first chunk: %v", err)
+	}
+
+	r, err := cache.GetReader("large-item")
+	if err != nil {
+		t.Fatalf("failed to open a reader while the write is still in progress: %v", err)
+	}
+	defer r.Close()
+
+	got := make([]byte, len("first chunk,"))
+
+	if _, err := io.ReadFull(r, got); err != nil {
+		t.Fatalf("failed to read the already-written chunk: %v", err)
+	}
+
+	if string(got) != "first chunk," {
+		t.Fatalf("got %q, want %q", got, "first chunk,")
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		rest := make([]byte, len("second chunk"))
+
+		if _, err := io.ReadFull(r, rest); err != nil {
+			t.Errorf("failed to read the second chunk once it arrived: %v", err)
+			return
+		}
+
+		if string(rest) != "second chunk" {
+			t.Errorf("got %q, want %q", rest, "second chunk")
+		}
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("the reader returned before the writer produced more data")
+	case <-time.After(100 * time.Millisecond):
+		t.Log("as expected, the reader is blocked waiting for more data")
+	}
+
+	if _, err := w.Write([]byte("second chunk")); err != nil {
+		t.Fatalf("failed to write the second chunk: %v", err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close the writer: %v", err)
+	}
+
+	<-done
+}
+
+func TestProcessSafeRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	cache := filecache.New("process-safe-test", filecache.WithProcessSafe(true))
+	defer cache.Destroy()
+
+	if err := cache.Set("locked-value", []byte("protected by flock")); err != nil {
+		t.Fatalf("failed to set a value in a process-safe cache: %v", err)
+	}
+
+	data, err := cache.Get("locked-value")
+	if err != nil {
+		t.Fatalf("failed to get a value from a process-safe cache: %v", err)
+	}
+
+	if string(data) != "protected by flock" {
+		t.Fatalf("got %q, want %q", data, "protected by flock")
+	}
+
+	if err := cache.Delete("locked-value"); err != nil {
+		t.Fatalf("failed to delete a value from a process-safe cache: %v", err)
+	}
+
+	if cache.Exists("locked-value") {
+		t.Fatal("expected the value to be gone after Delete")
+	}
+}
+
+func TestActionRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	cache := filecache.New("action-test")
+	defer cache.Destroy()
+
+	var id filecache.ActionID
+	copy(id[:], "a fixed action id")
+
+	body := []byte("the output of a build step")
+
+	out, size, err := cache.PutAction(id, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to put action: %v", err)
+	}
+
+	if size != int64(len(body)) {
+		t.Fatalf("got size %d, want %d", size, len(body))
+	}
+
+	entry, err := cache.GetAction(id)
+	if err != nil {
+		t.Fatalf("failed to get action: %v", err)
+	}
+
+	if entry.OutputID != out {
+		t.Fatalf("got output id %x, want %x", entry.OutputID, out)
+	}
+
+	if entry.Size != size {
+		t.Fatalf("got entry size %d, want %d", entry.Size, size)
+	}
+
+	got, err := os.ReadFile(cache.OutputFile(entry.OutputID))
+	if err != nil {
+		t.Fatalf("failed to read the output file directly: %v", err)
+	}
+
+	if !bytes.Equal(got, body) {
+		t.Fatalf("got %q, want %q", got, body)
+	}
+
+	var unknown filecache.ActionID
+	copy(unknown[:], "never stored")
+
+	if _, err := cache.GetAction(unknown); !errors.Is(err, filecache.ErrNotFound) {
+		t.Fatalf("got %v, want ErrNotFound", err)
+	}
+}
+
+func TestQuotaEviction(t *testing.T) {
+	t.Parallel()
+
+	cache := filecache.New("quota-test", filecache.WithMaxItems(2))
+	defer cache.Destroy()
+
+	for _, key := range []string{"one", "two", "three"} {
+		if err := cache.Set(key, []byte(key)); err != nil {
+			t.Fatalf("failed to set %q: %v", key, err)
+		}
+	}
+
+	stats := cache.Stats()
+
+	if stats.Items > 2 {
+		t.Fatalf("got %d items tracked, want at most 2", stats.Items)
+	}
+
+	if stats.Evictions == 0 {
+		t.Fatal("expected at least one eviction once maxItems was exceeded")
+	}
+
+	if cache.Exists("one") {
+		t.Fatal("expected the least-recently-used key to have been evicted")
+	}
+
+	if !cache.Exists("three") {
+		t.Fatal("expected the most-recently-set key to still be present")
+	}
+}
+
+func TestHeldOpenPoolEviction(t *testing.T) {
+	t.Parallel()
+
+	cache := filecache.New("held-open-test", filecache.WithHeldOpenMax(1))
+	defer cache.Destroy()
+
+	if err := cache.Set("a", []byte("value a")); err != nil {
+		t.Fatalf("failed to set %q: %v", "a", err)
+	}
+
+	if err := cache.Set("b", []byte("value b")); err != nil {
+		t.Fatalf("failed to set %q: %v", "b", err)
+	}
+
+	ra, err := cache.GetReader("a")
+	if err != nil {
+		t.Fatalf("failed to open a reader for %q: %v", "a", err)
+	}
+	defer ra.Close()
+
+	// With heldOpenMax=1, opening a reader for "b" evicts the pooled handle
+	// for "a" from the pool while ra is still reading through it; that
+	// shouldn't break ra.
+	rb, err := cache.GetReader("b")
+	if err != nil {
+		t.Fatalf("failed to open a reader for %q: %v", "b", err)
+	}
+	defer rb.Close()
+
+	gotA, err := io.ReadAll(ra)
+	if err != nil {
+		t.Fatalf("failed to read %q after its pooled handle was evicted: %v", "a", err)
+	}
+
+	if string(gotA) != "value a" {
+		t.Fatalf("got %q, want %q", gotA, "value a")
+	}
+
+	gotB, err := io.ReadAll(rb)
+	if err != nil {
+		t.Fatalf("failed to read %q: %v", "b", err)
+	}
+
+	if string(gotB) != "value b" {
+		t.Fatalf("got %q, want %q", gotB, "value b")
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	cache := filecache.New("codec-test", filecache.WithCodec(filecache.JSONCodec))
+	defer cache.Destroy()
+
+	type Payload struct {
+		Name string
+		N    int
+	}
+
+	want := Payload{Name: "a payload", N: 7}
+
+	if err := filecache.SetEncoded(cache, "payload", want); err != nil {
+		t.Fatalf("failed to set an encoded value: %v", err)
+	}
+
+	got, err := filecache.GetDecoded[Payload](cache, "payload")
+	if err != nil {
+		t.Fatalf("failed to get a decoded value: %v", err)
+	}
+
+	if got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	raw, err := cache.Get("payload")
+	if err != nil {
+		t.Fatalf("failed to get the raw encoded bytes: %v", err)
+	}
+
+	if !bytes.Contains(raw, []byte(`"a payload"`)) {
+		t.Fatalf("expected the JSON-encoded bytes to contain the Name field, got %q", raw)
+	}
+}
@@ -0,0 +1,181 @@
+package filecache
+
+import (
+	"container/list"
+	"os"
+	"sync"
+)
+
+// heldOpenPool keeps a bounded LRU of open *os.File handles for hot keys, so
+// repeated reads of the same item don't re-open (or re-read) it from
+// scratch. Handles are read through ReadAt, so concurrent callers sharing
+// one pooled handle don't race on its file offset.
+type heldOpenPool struct {
+	mu      sync.Mutex
+	max     uint
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// heldOpenEntry is a single pooled handle, refcounted so that evicting it
+// from the pool (LRU eviction, invalidate, or shutdown) doesn't close it out
+// from under a caller that's still reading through a checked-out reference.
+// The file is only actually closed once it's been removed from the pool and
+// every checkout has been released.
+type heldOpenEntry struct {
+	key  string
+	file *os.File
+
+	mu      sync.Mutex
+	refs    int
+	removed bool
+}
+
+// acquire registers one more checkout of the entry's handle. Every acquire
+// must be paired with a release.
+func (e *heldOpenEntry) acquire() {
+	e.mu.Lock()
+	e.refs++
+	e.mu.Unlock()
+}
+
+// release ends one checkout, closing the underlying file if the entry has
+// since been removed from the pool and this was the last outstanding
+// checkout.
+func (e *heldOpenEntry) release() {
+	e.mu.Lock()
+	e.refs--
+	closeNow := e.removed && e.refs == 0
+	e.mu.Unlock()
+
+	if closeNow {
+		e.file.Close()
+	}
+}
+
+// remove marks the entry as no longer reachable through the pool's index,
+// closing its file immediately if nothing is currently checked out, or
+// leaving that to the last release otherwise.
+func (e *heldOpenEntry) remove() {
+	e.mu.Lock()
+	e.removed = true
+	closeNow := e.refs == 0
+	e.mu.Unlock()
+
+	if closeNow {
+		e.file.Close()
+	}
+}
+
+func newHeldOpenPool(max uint) *heldOpenPool {
+	return &heldOpenPool{
+		max:     max,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// open returns a held-open handle for path, opening and caching it under
+// key if it isn't pooled yet, evicting the least-recently-used handle if
+// the pool is full. The returned entry is checked out (acquired) on behalf
+// of the caller, who must call its release method once done reading
+// through it.
+func (p *heldOpenPool) open(key, path string) (*heldOpenEntry, error) {
+	p.mu.Lock()
+	if el, ok := p.entries[key]; ok {
+		p.order.MoveToFront(el)
+		ent := el.Value.(*heldOpenEntry)
+		ent.acquire()
+		p.mu.Unlock()
+
+		return ent, nil
+	}
+	p.mu.Unlock()
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+
+	if el, ok := p.entries[key]; ok {
+		// Lost a race with another caller opening the same key; use theirs
+		// and close ours.
+		f.Close()
+		p.order.MoveToFront(el)
+		ent := el.Value.(*heldOpenEntry)
+		ent.acquire()
+		p.mu.Unlock()
+
+		return ent, nil
+	}
+
+	var evicted *heldOpenEntry
+
+	if uint(p.order.Len()) >= p.max {
+		if tail := p.order.Back(); tail != nil {
+			p.order.Remove(tail)
+
+			ent := tail.Value.(*heldOpenEntry)
+			delete(p.entries, ent.key)
+			evicted = ent
+		}
+	}
+
+	ent := &heldOpenEntry{key: key, file: f, refs: 1}
+	p.entries[key] = p.order.PushFront(ent)
+
+	p.mu.Unlock()
+
+	if evicted != nil {
+		evicted.remove()
+	}
+
+	return ent, nil
+}
+
+// invalidate drops the pooled handle for key, if any, closing it once every
+// outstanding checkout of it has released. Called whenever key is
+// overwritten, deleted or evicted so a stale handle is never served again.
+func (p *heldOpenPool) invalidate(key string) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	el, ok := p.entries[key]
+	if ok {
+		p.order.Remove(el)
+		delete(p.entries, key)
+	}
+	p.mu.Unlock()
+
+	if ok {
+		el.Value.(*heldOpenEntry).remove()
+	}
+}
+
+// closeAll drops every pooled handle, closing each once its outstanding
+// checkouts have released. Called when the cache shuts down.
+func (p *heldOpenPool) closeAll() {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+
+	entries := make([]*heldOpenEntry, 0, p.order.Len())
+	for el := p.order.Front(); el != nil; el = el.Next() {
+		entries = append(entries, el.Value.(*heldOpenEntry))
+	}
+
+	p.order.Init()
+	p.entries = make(map[string]*list.Element)
+
+	p.mu.Unlock()
+
+	for _, ent := range entries {
+		ent.remove()
+	}
+}
@@ -12,6 +12,10 @@ type item struct {
 	content []byte
 	mu      sync.Mutex
 
+	path        string
+	size        int64
+	processSafe bool
+
 	AccesedAt  time.Time
 	ModifiedAt time.Time
 }
@@ -23,63 +27,165 @@ func (i *item) Duration() time.Duration {
 	return time.Since(i.ModifiedAt)
 }
 
-func (i *item) Access() []byte {
+// Access returns the item's content, marking it as recently used. Items
+// larger than the cache's memory limit aren't held in RAM and are instead
+// read from disk on every access, under a shared lock when process-safety
+// is enabled.
+func (i *item) Access() ([]byte, error) {
 	i.mu.Lock()
 	defer i.mu.Unlock()
 
 	i.AccesedAt = time.Now()
 
-	return i.content
+	if i.content != nil {
+		return i.content, nil
+	}
+
+	if !i.processSafe {
+		return os.ReadFile(i.path)
+	}
+
+	var content []byte
+
+	err := withFileLock(i.path, false, func() error {
+		var err error
+		content, err = os.ReadFile(i.path)
+		return err
+	})
+
+	return content, err
 }
 
-func getCacheItem(path string, maxSize int64) (*item, error) {
-	info, err := os.Stat(path)
-	if err != nil {
-		return nil, ErrNotFound
-	} else if info.IsDir() {
-		return nil, ErrIsDirectory
-	} else if info.Size() > maxSize {
-		return nil, ErrTooLarge
+// getCacheItem loads the on-disk metadata for path, reading the content
+// into memory only if it's within memLimit. Larger items are tracked by
+// path, size and mtime alone, and read from disk lazily through Access.
+// When processSafe is set, the read is taken under a shared lock so a
+// concurrent writer in another process can't be observed mid-write.
+func getCacheItem(path string, maxSize, memLimit int64, processSafe bool) (*item, error) {
+	read := func() (*item, error) {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, ErrNotFound
+		} else if info.IsDir() {
+			return nil, ErrIsDirectory
+		} else if info.Size() > maxSize {
+			return nil, ErrTooLarge
+		}
+
+		it := &item{
+			path:        path,
+			size:        info.Size(),
+			processSafe: processSafe,
+			ModifiedAt:  info.ModTime(),
+		}
+
+		if info.Size() <= memLimit {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+
+			it.content = content
+		}
+
+		return it, nil
 	}
 
-	content, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
+	if !processSafe {
+		return read()
 	}
 
-	item := &item{
-		content:    content,
-		ModifiedAt: info.ModTime(),
+	var (
+		it  *item
+		err error
+	)
+
+	lockErr := withFileLock(path, false, func() error {
+		it, err = read()
+		return nil
+	})
+	if lockErr != nil {
+		return nil, lockErr
 	}
 
-	return item, nil
+	return it, err
 }
 
-func setCacheItem(path string, content []byte, maxSize int64) (*item, error) {
+// setCacheItem stages content to a sibling ".tmp" file and renames it into
+// place, so a reader never observes a partial write. When processSafe is
+// set, the whole stage-and-rename happens under an exclusive lock so two
+// processes sharing a namespace can't tear each other's writes. Like
+// getCacheItem, content is only kept in the returned item when it's within
+// memLimit; larger items are tracked by path, size and mtime alone and read
+// back from disk through Access.
+func setCacheItem(path string, content []byte, maxSize, memLimit int64, processSafe bool) (*item, error) {
 	if int64(len(content)) > maxSize {
 		return nil, ErrTooLarge
 	}
 
-	item := &item{
-		content: content,
-	}
+	write := func() error {
+		dir := filepath.Dir(path)
+
+		if err := os.MkdirAll(dir, fs.ModePerm); err != nil {
+			return ErrInvalidKey
+		}
 
-	dir := filepath.Dir(path)
+		tmp := path + ".tmp"
 
-	if err := os.MkdirAll(dir, fs.ModePerm); err != nil {
-		return nil, ErrInvalidKey
+		if err := os.WriteFile(tmp, content, os.FileMode(0o644)); err != nil {
+			return err
+		}
+
+		return os.Rename(tmp, path)
+	}
+
+	var err error
+	if processSafe {
+		err = withFileLock(path, true, write)
+	} else {
+		err = write()
 	}
 
-	err := os.WriteFile(path, content, os.FileMode(0o644))
 	if err != nil {
 		return nil, err
 	}
 
-	item.ModifiedAt = time.Now()
+	it := &item{
+		path:        path,
+		size:        int64(len(content)),
+		processSafe: processSafe,
+		ModifiedAt:  time.Now(),
+	}
+
+	if int64(len(content)) <= memLimit {
+		it.content = content
+	}
 
-	return item, nil
+	return it, nil
 }
 
-func deleteCacheItem(path string) error {
-	return os.Remove(path)
+func deleteCacheItem(path string, processSafe bool) error {
+	if !processSafe {
+		return os.Remove(path)
+	}
+
+	return withFileLock(path, true, func() error {
+		return os.Remove(path)
+	})
+}
+
+// statCacheItem tracks an item that lives only on disk, without reading its
+// content into memory. Used once a SetWriter finishes streaming a value in.
+func statCacheItem(path string, processSafe bool) (*item, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	return &item{
+		path:        path,
+		size:        info.Size(),
+		processSafe: processSafe,
+		ModifiedAt:  info.ModTime(),
+	}, nil
 }
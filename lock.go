@@ -0,0 +1,58 @@
+package filecache
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// withFileLock runs fn while holding an advisory lock on a sibling
+// "<path>.lock" file — shared for reads, exclusive for writes and deletes —
+// so two processes sharing a namespace don't tear each other's files. The
+// lock is taken on a sibling file rather than path itself so it survives
+// the rename-into-place used by setCacheItem and the streaming writer.
+func withFileLock(path string, exclusive bool, fn func() error) error {
+	f, err := acquireFileLock(path, exclusive)
+	if err != nil {
+		return err
+	}
+	defer releaseFileLock(f)
+
+	return fn()
+}
+
+// acquireFileLock takes the lock for path and returns the open lock file,
+// for callers that need to hold it for longer than a single operation (the
+// streaming API).
+func acquireFileLock(path string, exclusive bool) (*os.File, error) {
+	lockPath := path + ".lock"
+
+	if err := os.MkdirAll(filepath.Dir(lockPath), fs.ModePerm); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lockFile(f, exclusive); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// releaseFileLock unlocks and closes a lock file acquired with
+// acquireFileLock.
+func releaseFileLock(f *os.File) error {
+	unlockErr := unlockFile(f)
+	closeErr := f.Close()
+
+	if unlockErr != nil {
+		return unlockErr
+	}
+
+	return closeErr
+}
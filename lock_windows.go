@@ -0,0 +1,59 @@
+//go:build windows
+
+package filecache
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Same approach Go's own build cache uses on Windows: LockFileEx/UnlockFileEx
+// from kernel32, since the standard library doesn't expose them publicly.
+var (
+	modkernel32      = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = modkernel32.NewProc("LockFileEx")
+	procUnlockFileEx = modkernel32.NewProc("UnlockFileEx")
+)
+
+const lockfileExclusiveLock = 0x2
+
+func lockFile(f *os.File, exclusive bool) error {
+	var flags uint32
+	if exclusive {
+		flags = lockfileExclusiveLock
+	}
+
+	ol := new(syscall.Overlapped)
+
+	r, _, err := procLockFileEx.Call(
+		f.Fd(),
+		uintptr(flags),
+		0,
+		^uintptr(0),
+		^uintptr(0),
+		uintptr(unsafe.Pointer(ol)),
+	)
+	if r == 0 {
+		return err
+	}
+
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	ol := new(syscall.Overlapped)
+
+	r, _, err := procUnlockFileEx.Call(
+		f.Fd(),
+		0,
+		^uintptr(0),
+		^uintptr(0),
+		uintptr(unsafe.Pointer(ol)),
+	)
+	if r == 0 {
+		return err
+	}
+
+	return nil
+}
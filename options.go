@@ -2,34 +2,78 @@ package filecache
 
 import "time"
 
-type fileCacheOptFn func(*FileCache)
+type fileCacheOptFn func(*sharedState)
 
 func WithMaxItems(maxItems uint) fileCacheOptFn {
-	return func(fc *FileCache) {
-		fc.maxItems = maxItems
+	return func(st *sharedState) {
+		st.maxItems = maxItems
 	}
 }
 
 func WithMaxSize(maxSize int64) fileCacheOptFn {
-	return func(fc *FileCache) {
-		fc.maxSize = maxSize
+	return func(st *sharedState) {
+		st.maxSize = maxSize
+	}
+}
+
+// WithMemoryLimit sets the largest item size, in bytes, that's kept in
+// memory after being read or written. Items above the limit are still
+// capped by `WithMaxSize` but are tracked only by path, size and mtime, and
+// read from disk on every access.
+//
+// Defaults to the same value as `WithMaxSize`, i.e. everything that fits on
+// disk is also held in memory.
+func WithMemoryLimit(limit int64) fileCacheOptFn {
+	return func(st *sharedState) {
+		st.memLimit = limit
 	}
 }
 
 func WithTTL(expiresIn time.Duration) fileCacheOptFn {
-	return func(fc *FileCache) {
-		fc.ttl = expiresIn
+	return func(st *sharedState) {
+		st.ttl = expiresIn
 	}
 }
 
 func WithCheckInterval(checkEvery time.Duration) fileCacheOptFn {
-	return func(fc *FileCache) {
-		fc.checkInterval = checkEvery
+	return func(st *sharedState) {
+		st.checkInterval = checkEvery
 	}
 }
 
 func WithPipeSize(pipeSize uint) fileCacheOptFn {
-	return func(fc *FileCache) {
-		fc.pipeSize = pipeSize
+	return func(st *sharedState) {
+		st.pipeSize = pipeSize
+	}
+}
+
+// WithProcessSafe makes the cache take an advisory file lock (shared for
+// reads, exclusive for writes and deletes) around every on-disk operation,
+// so two processes sharing a namespace don't tear each other's entries.
+//
+// Disabled by default: the extra flock syscalls only pay for themselves
+// when the namespace is actually shared across processes.
+func WithProcessSafe(processSafe bool) fileCacheOptFn {
+	return func(st *sharedState) {
+		st.processSafe = processSafe
+	}
+}
+
+// WithHeldOpenMax bounds the LRU pool of *os.File handles kept open for hot
+// keys, so repeated GetReader calls for the same item read through an
+// already-open handle instead of re-opening it from scratch.
+//
+// Disabled by default (0).
+func WithHeldOpenMax(max uint) fileCacheOptFn {
+	return func(st *sharedState) {
+		st.heldOpenMax = max
+	}
+}
+
+// WithCodec sets the Codec used by `GetDecoded`/`SetEncoded` and
+// `DecodeFrom`/`EncodeTo`. Defaults to `GobCodec`.
+func WithCodec(codec Codec) fileCacheOptFn {
+	return func(st *sharedState) {
+		st.codec = codec
 	}
 }
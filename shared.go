@@ -0,0 +1,404 @@
+package filecache
+
+import (
+	"container/list"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// sharedState is the state actually shared by every FileCache handle
+// constructed with the same namespace: one in-memory index, one vacuum
+// goroutine and one held-open file pool, instead of each handle racing its
+// own copy against the same on-disk files. Modeled on Arvados' sharedCache
+// indirection.
+type sharedState struct {
+	namespace string
+
+	mu         sync.Mutex
+	wg         sync.WaitGroup
+	keyItem    map[string]*list.Element
+	lru        *list.List
+	totalBytes int64
+	evictions  uint64
+	pipe       chan string
+	shutdown   chan struct{}
+
+	pipeSize      uint
+	maxItems      uint
+	maxSize       int64
+	memLimit      int64
+	ttl           time.Duration
+	checkInterval time.Duration
+	processSafe   bool
+	heldOpenMax   uint
+	codec         Codec
+
+	held *heldOpenPool
+
+	// refs tracks how many FileCache handles point at this state. Guarded
+	// by sharedMu, not mu.
+	refs int
+}
+
+// lruEntry is the value held by each element of sharedState.lru, letting an
+// eviction walk from the tail go straight from list element to cache key
+// and item without a reverse lookup.
+type lruEntry struct {
+	key  string
+	item *item
+}
+
+var (
+	sharedMu     sync.Mutex
+	sharedStates = make(map[string]*sharedState)
+)
+
+// acquireSharedState returns the sharedState for namespace, creating it
+// (and applying options) if this is the first handle for it. Options
+// passed to later New calls for an already-running namespace are ignored,
+// since the state they'd configure already exists.
+func acquireSharedState(namespace string, options []fileCacheOptFn) *sharedState {
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+
+	if st, ok := sharedStates[namespace]; ok {
+		st.refs++
+
+		return st
+	}
+
+	st := &sharedState{
+		namespace:     namespace,
+		checkInterval: defaultCheckInterval,
+		pipeSize:      defaultPipeSize,
+		maxItems:      defaultMaxItems,
+		maxSize:       defaultMaxSize,
+		memLimit:      -1,
+		ttl:           defaultTTL,
+		codec:         GobCodec,
+		refs:          1,
+	}
+
+	for _, opt := range options {
+		opt(st)
+	}
+
+	if st.memLimit < 0 {
+		st.memLimit = st.maxSize
+	}
+
+	st.pipe = make(chan string, st.pipeSize)
+	st.keyItem = make(map[string]*list.Element, 0)
+	st.lru = list.New()
+	st.shutdown = make(chan struct{}, 1)
+
+	if st.heldOpenMax > 0 {
+		st.held = newHeldOpenPool(st.heldOpenMax)
+	}
+
+	sharedStates[namespace] = st
+
+	go st.vacuum()
+
+	return st
+}
+
+// releaseSharedState decrements the handle count for st and, once the last handle for
+// its namespace has gone away, stops the vacuum goroutine and drops the
+// in-memory index and held-open pool. The on-disk cache is left untouched.
+func releaseSharedState(st *sharedState) {
+	sharedMu.Lock()
+	st.refs--
+	last := st.refs <= 0
+	if last {
+		delete(sharedStates, st.namespace)
+	}
+	sharedMu.Unlock()
+
+	if !last {
+		return
+	}
+
+	close(st.pipe)
+	close(st.shutdown)
+	<-time.After(time.Microsecond)
+
+	st.mu.Lock()
+	st.keyItem = nil
+	st.lru = nil
+	st.mu.Unlock()
+
+	st.wg.Wait()
+
+	st.held.closeAll()
+}
+
+func (st *sharedState) keyToPath(key string) string {
+	return filepath.Join(st.getNamespaceDir(), key)
+}
+
+func (st *sharedState) getNamespaceDir() string {
+	return filepath.Join(os.TempDir(), "fc-namespaces", st.namespace)
+}
+
+func (st *sharedState) get(key string) ([]byte, error) {
+	item, err := st.getItem(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return item.Access()
+}
+
+func (st *sharedState) exists(key string) bool {
+	_, err := st.getItem(key)
+
+	return err == nil
+}
+
+func (st *sharedState) set(key string, content []byte) error {
+	path := st.keyToPath(key)
+
+	item, err := setCacheItem(path, content, st.maxSize, st.memLimit, st.processSafe)
+	if errors.Is(err, syscall.ENOSPC) {
+		st.evictForSpace(int64(len(content)))
+
+		item, err = setCacheItem(path, content, st.maxSize, st.memLimit, st.processSafe)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	st.touch(key, item)
+
+	return nil
+}
+
+func (st *sharedState) delete(key string) error {
+	path := st.keyToPath(key)
+
+	st.removeItem(key, true)
+
+	return deleteCacheItem(path, st.processSafe)
+}
+
+func (st *sharedState) sizeInMemory() int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	n := 0
+
+	for el := st.lru.Front(); el != nil; el = el.Next() {
+		if el.Value.(*lruEntry).item.content != nil {
+			n++
+		}
+	}
+
+	return n
+}
+
+func (st *sharedState) stats() Stats {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return Stats{
+		Items:     st.lru.Len(),
+		Bytes:     st.totalBytes,
+		Evictions: st.evictions,
+	}
+}
+
+func (st *sharedState) getItem(key string) (*item, error) {
+	st.mu.Lock()
+	if el, ok := st.keyItem[key]; ok {
+		st.lru.MoveToFront(el)
+		item := el.Value.(*lruEntry).item
+		st.mu.Unlock()
+
+		return item, nil
+	}
+	st.mu.Unlock()
+
+	path := st.keyToPath(key)
+
+	item, err := getCacheItem(path, st.maxSize, st.memLimit, st.processSafe)
+	if err != nil {
+		return nil, err
+	}
+
+	st.touch(key, item)
+
+	return item, nil
+}
+
+// touch inserts key at the front of the LRU list (or moves it there if
+// already present) and evicts from the tail until the cache is back within
+// its maxItems/maxSize quotas.
+func (st *sharedState) touch(key string, item *item) {
+	st.mu.Lock()
+
+	if el, ok := st.keyItem[key]; ok {
+		st.totalBytes -= el.Value.(*lruEntry).item.size
+		el.Value = &lruEntry{key: key, item: item}
+		st.lru.MoveToFront(el)
+	} else {
+		st.keyItem[key] = st.lru.PushFront(&lruEntry{key: key, item: item})
+	}
+
+	st.totalBytes += item.size
+
+	st.evictLocked()
+
+	st.mu.Unlock()
+
+	st.held.invalidate(key)
+}
+
+// evictLocked evicts from the tail of the LRU list until the cache is
+// within its maxItems/maxSize quotas. Callers must hold st.mu.
+func (st *sharedState) evictLocked() {
+	for st.overQuotaLocked() {
+		victim := st.evictionVictimLocked()
+		if victim == nil {
+			return
+		}
+
+		entry := victim.Value.(*lruEntry)
+
+		st.lru.Remove(victim)
+		delete(st.keyItem, entry.key)
+		st.totalBytes -= entry.item.size
+		st.evictions++
+
+		deleteCacheItem(st.keyToPath(entry.key), st.processSafe)
+		st.held.invalidate(entry.key)
+	}
+}
+
+// evictionVictimLocked walks the LRU list from the tail looking for the
+// oldest entry whose own size doesn't already exceed maxSize. An entry
+// larger than maxSize by itself (e.g. something streamed in via SetWriter,
+// which isn't subject to the whole-payload size check `Set` applies) is
+// never chosen as a victim, whichever quota is exceeded: evicting it can never bring
+// totalBytes back under quota, so doing so just deletes the item that was
+// written moments ago for no benefit. Returns nil if every remaining entry
+// is exempt. Callers must hold st.mu.
+func (st *sharedState) evictionVictimLocked() *list.Element {
+	for el := st.lru.Back(); el != nil; el = el.Prev() {
+		entry := el.Value.(*lruEntry)
+		if st.maxSize > 0 && entry.item.size > st.maxSize {
+			continue
+		}
+
+		return el
+	}
+
+	return nil
+}
+
+func (st *sharedState) overQuotaLocked() bool {
+	return (st.maxItems > 0 && uint(len(st.keyItem)) > st.maxItems) ||
+		(st.maxSize > 0 && st.totalBytes > st.maxSize)
+}
+
+func (st *sharedState) removeItem(key string, onlyMemory bool) {
+	st.mu.Lock()
+
+	if el, ok := st.keyItem[key]; ok {
+		st.lru.Remove(el)
+		delete(st.keyItem, key)
+		st.totalBytes -= el.Value.(*lruEntry).item.size
+	}
+
+	st.mu.Unlock()
+
+	st.held.invalidate(key)
+
+	if !onlyMemory {
+		path := st.keyToPath(key)
+		deleteCacheItem(path, st.processSafe)
+	}
+}
+
+// evictForSpace is called synchronously when a write fails with ENOSPC. It
+// first evicts the least-recently-used entries, stopping as soon as it's
+// freed at least needed bytes (a plausible amount for the retry that
+// triggered it to succeed) rather than walking the whole LRU list, then
+// sweeps anything already past its TTL regardless of access order, the
+// same safety net rclone's vfs-cache-max-age pass uses.
+func (st *sharedState) evictForSpace(needed int64) {
+	st.mu.Lock()
+
+	var freed int64
+
+	for freed < needed && st.lru.Len() > 0 {
+		tail := st.lru.Back()
+		entry := tail.Value.(*lruEntry)
+
+		st.lru.Remove(tail)
+		delete(st.keyItem, entry.key)
+		st.totalBytes -= entry.item.size
+		st.evictions++
+		freed += entry.item.size
+
+		path := st.keyToPath(entry.key)
+
+		st.mu.Unlock()
+		deleteCacheItem(path, st.processSafe)
+		st.held.invalidate(entry.key)
+		st.mu.Lock()
+	}
+
+	var expired []string
+
+	for el := st.lru.Front(); el != nil; el = el.Next() {
+		entry := el.Value.(*lruEntry)
+		if entry.item.Duration() > st.ttl {
+			expired = append(expired, entry.key)
+		}
+	}
+
+	st.mu.Unlock()
+
+	for _, key := range expired {
+		st.removeItem(key, false)
+	}
+}
+
+func (st *sharedState) vacuum() {
+	if st.checkInterval < 1 {
+		return
+	}
+
+	st.wg.Add(1)
+
+	for {
+		select {
+		case _ = <-st.shutdown:
+			st.wg.Done()
+			return
+		case <-time.After(st.checkInterval):
+			st.mu.Lock()
+
+			var expired []string
+
+			for el := st.lru.Front(); el != nil; el = el.Next() {
+				entry := el.Value.(*lruEntry)
+				if entry.item.Duration() > st.ttl {
+					expired = append(expired, entry.key)
+				}
+			}
+
+			st.mu.Unlock()
+
+			for _, key := range expired {
+				st.removeItem(key, false)
+			}
+		}
+	}
+}
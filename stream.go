@@ -0,0 +1,364 @@
+package filecache
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// writeState tracks a SetWriter that's still in progress, so a concurrent
+// GetReader for the same key can stream the partially written file instead
+// of failing with ErrNotFound.
+type writeState struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	tmp  string
+	done bool
+}
+
+var (
+	writesMu sync.Mutex
+	writes   = make(map[string]*writeState)
+)
+
+// cacheWriter stages writes to a sibling ".tmp" file and atomically renames
+// it into place on Close, so a reader opening the final path never observes
+// a half-written file.
+type cacheWriter struct {
+	fc    *FileCache
+	key   string
+	path  string
+	file  *os.File
+	state *writeState
+	lock  *os.File
+}
+
+// SetWriter returns an io.WriteCloser that streams content for key directly
+// to disk, without buffering it fully in memory. The write is staged under
+// a sibling ".tmp" file and renamed into place on Close; until then, a
+// concurrent GetReader for the same key streams straight from the temp file
+// as bytes arrive. When the cache was built `WithProcessSafe`, the rename is
+// held off from other processes by an exclusive lock for the writer's
+// entire lifetime.
+func (fc *FileCache) SetWriter(key string) (io.WriteCloser, error) {
+	path := fc.keyToPath(key)
+
+	if err := os.MkdirAll(filepath.Dir(path), fs.ModePerm); err != nil {
+		return nil, ErrInvalidKey
+	}
+
+	var lock *os.File
+
+	if fc.state.processSafe {
+		l, err := acquireFileLock(path, true)
+		if err != nil {
+			return nil, err
+		}
+
+		lock = l
+	}
+
+	tmp := path + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		if lock != nil {
+			releaseFileLock(lock)
+		}
+
+		return nil, err
+	}
+
+	state := &writeState{tmp: tmp}
+	state.cond = sync.NewCond(&state.mu)
+
+	writesMu.Lock()
+	writes[path] = state
+	writesMu.Unlock()
+
+	return &cacheWriter{fc: fc, key: key, path: path, file: f, state: state, lock: lock}, nil
+}
+
+func (w *cacheWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+
+	w.state.mu.Lock()
+	if err != nil {
+		// Mark the write done on error as well: a caller that abandons the
+		// writer without calling Close would otherwise leave a concurrent
+		// streamReader blocked on cond.Wait forever, waiting for a rename
+		// that's never coming.
+		w.state.done = true
+	}
+	w.state.cond.Broadcast()
+	w.state.mu.Unlock()
+
+	if err != nil {
+		writesMu.Lock()
+		delete(writes, w.path)
+		writesMu.Unlock()
+	}
+
+	return n, err
+}
+
+func (w *cacheWriter) Close() error {
+	if w.lock != nil {
+		defer releaseFileLock(w.lock)
+	}
+
+	if err := w.file.Sync(); err != nil {
+		w.file.Close()
+		return err
+	}
+
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(w.state.tmp, w.path); err != nil {
+		return err
+	}
+
+	w.state.mu.Lock()
+	w.state.done = true
+	w.state.cond.Broadcast()
+	w.state.mu.Unlock()
+
+	writesMu.Lock()
+	delete(writes, w.path)
+	writesMu.Unlock()
+
+	it, err := statCacheItem(w.path, w.fc.state.processSafe)
+	if err != nil {
+		return nil
+	}
+
+	w.fc.state.touch(w.key, it)
+
+	return nil
+}
+
+// streamReader reads a file that a concurrent SetWriter may still be
+// growing, blocking for more data instead of returning io.EOF early.
+type streamReader struct {
+	file  *os.File
+	state *writeState
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.file.Read(p)
+		if n > 0 {
+			return n, nil
+		}
+
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+
+		r.state.mu.Lock()
+
+		// Re-read under the lock before waiting: a Write landing (and
+		// broadcasting) in the window between the read above and this Lock
+		// would otherwise be missed, since we weren't in Wait yet to
+		// observe it.
+		n, err = r.file.Read(p)
+		if n > 0 {
+			r.state.mu.Unlock()
+			return n, nil
+		}
+
+		if err != nil && err != io.EOF {
+			r.state.mu.Unlock()
+			return n, err
+		}
+
+		if r.state.done {
+			r.state.mu.Unlock()
+			return r.file.Read(p)
+		}
+
+		r.state.cond.Wait()
+		r.state.mu.Unlock()
+	}
+}
+
+func (r *streamReader) Close() error {
+	return r.file.Close()
+}
+
+// lockedFileReader wraps an *os.File opened for reading that's holding a
+// shared lock for its whole lifetime, releasing it on Close.
+type lockedFileReader struct {
+	*os.File
+	lock *os.File
+}
+
+func (r *lockedFileReader) Close() error {
+	closeErr := r.File.Close()
+	lockErr := releaseFileLock(r.lock)
+
+	if closeErr != nil {
+		return closeErr
+	}
+
+	return lockErr
+}
+
+// pooledReader reads through a handle borrowed from the cache's held-open
+// pool via ReadAt, so the handle itself stays open and pooled for the next
+// caller. Close only releases this reader's checkout on the entry, letting
+// the pool close the handle once it's been evicted/invalidated and every
+// other checkout has released too. When the cache was built
+// `WithProcessSafe`, lock holds the shared lock taken for this reader's
+// lifetime, same as the non-pooled path.
+type pooledReader struct {
+	*io.SectionReader
+	entry *heldOpenEntry
+	lock  *os.File
+}
+
+func (r pooledReader) Close() error {
+	r.entry.release()
+
+	if r.lock != nil {
+		return releaseFileLock(r.lock)
+	}
+
+	return nil
+}
+
+// GetReader returns an io.ReadCloser for the content stored at key,
+// streaming directly from disk instead of buffering it fully in memory. If
+// a SetWriter for the same key is still in progress, the returned reader
+// blocks for new bytes as they're written rather than returning io.EOF
+// early. When the cache was built `WithHeldOpenMax`, the read goes through
+// the held-open pool instead of opening a fresh handle. When built
+// `WithProcessSafe`, a shared lock is held for the reader's entire
+// lifetime, pooled or not; a reader streaming from an in-progress
+// SetWriter takes no lock.
+func (fc *FileCache) GetReader(key string) (io.ReadCloser, error) {
+	path := fc.keyToPath(key)
+
+	writesMu.Lock()
+	state, inProgress := writes[path]
+	writesMu.Unlock()
+
+	if inProgress {
+		f, err := os.Open(state.tmp)
+		if err == nil {
+			return &streamReader{file: f, state: state}, nil
+		}
+
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		// The writer finished and renamed tmp to path in the window between
+		// the writes[path] lookup above and the Open here; fall through and
+		// read the now-complete final file instead.
+	}
+
+	if fc.state.held != nil {
+		var lock *os.File
+
+		if fc.state.processSafe {
+			l, err := acquireFileLock(path, false)
+			if err != nil {
+				return nil, err
+			}
+
+			lock = l
+		}
+
+		ent, err := fc.state.held.open(key, path)
+		if err != nil {
+			if lock != nil {
+				releaseFileLock(lock)
+			}
+
+			if os.IsNotExist(err) {
+				return nil, ErrNotFound
+			}
+
+			return nil, err
+		}
+
+		info, err := ent.file.Stat()
+		if err != nil {
+			ent.release()
+
+			if lock != nil {
+				releaseFileLock(lock)
+			}
+
+			return nil, err
+		}
+
+		if info.IsDir() {
+			ent.release()
+
+			if lock != nil {
+				releaseFileLock(lock)
+			}
+
+			return nil, ErrIsDirectory
+		}
+
+		return pooledReader{SectionReader: io.NewSectionReader(ent.file, 0, info.Size()), entry: ent, lock: lock}, nil
+	}
+
+	var lock *os.File
+
+	if fc.state.processSafe {
+		l, err := acquireFileLock(path, false)
+		if err != nil {
+			return nil, err
+		}
+
+		lock = l
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if lock != nil {
+			releaseFileLock(lock)
+		}
+
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+
+		if lock != nil {
+			releaseFileLock(lock)
+		}
+
+		return nil, err
+	}
+
+	if info.IsDir() {
+		f.Close()
+
+		if lock != nil {
+			releaseFileLock(lock)
+		}
+
+		return nil, ErrIsDirectory
+	}
+
+	if lock != nil {
+		return &lockedFileReader{File: f, lock: lock}, nil
+	}
+
+	return f, nil
+}